@@ -0,0 +1,163 @@
+// Package concurrent provides a thread-safe wrapper around hlltc.Sketch
+// for servers that ingest from many goroutines.
+package concurrent
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"unsafe"
+
+	hlltc "github.com/junxie6/hyperloglog-1"
+)
+
+// ConcurrentSketch shards inserts across N inner hlltc.Sketch values,
+// chosen by hashing the element mod N, so elements landing in different
+// shards can be inserted concurrently without a single global lock.
+// Routing by hash preserves HLL semantics: estimating cardinality is
+// just merging the shards back into one sketch.
+//
+// Each shard has its own RWMutex. Insert and Merge take the write lock
+// because they mutate the shard directly, and Estimate also takes the
+// write lock because reading an hlltc.Sketch is not always free of side
+// effects (its Estimate, for one, folds pending sparse entries into its
+// sparse list).
+type ConcurrentSketch struct {
+	precision uint8
+	mu        []sync.RWMutex
+	shards    []*hlltc.Sketch
+}
+
+// NewConcurrent creates a ConcurrentSketch with the given number of
+// shards, each an hlltc.Sketch built at the given precision.
+func NewConcurrent(precision uint8, shards int) (*ConcurrentSketch, error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("shards has to be >= 1")
+	}
+
+	cs := &ConcurrentSketch{
+		precision: precision,
+		mu:        make([]sync.RWMutex, shards),
+		shards:    make([]*hlltc.Sketch, shards),
+	}
+	for i := range cs.shards {
+		sk, err := hlltc.New(precision)
+		if err != nil {
+			return nil, err
+		}
+		cs.shards[i] = sk
+	}
+	return cs, nil
+}
+
+// Insert adds e to the sketch, routing it to a shard by hashing e so the
+// same element is always handled by the same shard.
+func (cs *ConcurrentSketch) Insert(e []byte) {
+	i := cs.shardFor(e)
+	cs.mu[i].Lock()
+	cs.shards[i].Insert(e)
+	cs.mu[i].Unlock()
+}
+
+// Estimate returns the cardinality estimate across all shards.
+func (cs *ConcurrentSketch) Estimate() uint64 {
+	return cs.mergeShards().Estimate()
+}
+
+// Merge combines other into cs. cs and other must have the same number
+// of shards.
+func (cs *ConcurrentSketch) Merge(other *ConcurrentSketch) error {
+	if len(other.shards) != len(cs.shards) {
+		return fmt.Errorf("shard counts must be equal")
+	}
+
+	for i := range cs.shards {
+		if err := cs.mergeShard(i, other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeShard locks shard i on both cs and other before merging. The two
+// mutexes are always acquired in the same order across the pair,
+// regardless of which side is "cs" and which is "other", by comparing the
+// ConcurrentSketch pointers: without that, a.Merge(b) running concurrently
+// with b.Merge(a) could each grab their own cs.mu[i] and then block
+// forever waiting on the other's, an AB-BA deadlock.
+func (cs *ConcurrentSketch) mergeShard(i int, other *ConcurrentSketch) error {
+	if uintptr(unsafe.Pointer(cs)) < uintptr(unsafe.Pointer(other)) {
+		cs.mu[i].Lock()
+		defer cs.mu[i].Unlock()
+		other.mu[i].RLock()
+		defer other.mu[i].RUnlock()
+	} else {
+		other.mu[i].RLock()
+		defer other.mu[i].RUnlock()
+		cs.mu[i].Lock()
+		defer cs.mu[i].Unlock()
+	}
+
+	return cs.shards[i].Merge(other.shards[i])
+}
+
+// mergeShards folds every shard into a single hlltc.Sketch, taking each
+// shard's write lock in turn.
+func (cs *ConcurrentSketch) mergeShards() *hlltc.Sketch {
+	merged, _ := hlltc.New(cs.precision)
+	for i := range cs.shards {
+		cs.mu[i].Lock()
+		_ = merged.Merge(cs.shards[i])
+		cs.mu[i].Unlock()
+	}
+	return merged
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler by merging all
+// shards into a single hlltc.Sketch and marshaling that.
+func (cs *ConcurrentSketch) MarshalBinary() ([]byte, error) {
+	return cs.mergeShards().MarshalBinary()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler by decoding the
+// data into shard 0, preserving cs's existing shard count (MarshalBinary
+// merges every shard into one sketch, so there's nothing to put in the
+// rest). The remaining shards start out empty rather than being dropped,
+// so a round trip through Marshal/Unmarshal doesn't silently collapse cs
+// down to a single mutex for all future inserts.
+func (cs *ConcurrentSketch) UnmarshalBinary(data []byte) error {
+	sk := &hlltc.Sketch{}
+	if err := sk.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	n := len(cs.shards)
+	if n == 0 {
+		n = 1
+	}
+	precision := sk.Precision()
+
+	shards := make([]*hlltc.Sketch, n)
+	shards[0] = sk
+	for i := 1; i < n; i++ {
+		empty, err := hlltc.New(precision)
+		if err != nil {
+			return err
+		}
+		shards[i] = empty
+	}
+
+	cs.precision = precision
+	cs.mu = make([]sync.RWMutex, n)
+	cs.shards = shards
+	return nil
+}
+
+// shardFor picks the shard e is routed to. This hash is independent of
+// the hasher the inner hlltc.Sketch uses for register placement; it only
+// needs to spread elements evenly across shards.
+func (cs *ConcurrentSketch) shardFor(e []byte) int {
+	h := fnv.New64a()
+	h.Write(e)
+	return int(h.Sum64() % uint64(len(cs.shards)))
+}
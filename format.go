@@ -0,0 +1,86 @@
+package hlltc
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+)
+
+// frameMagic identifies the framed (versionFramed) wire format, so a
+// reader can fail fast on data that isn't an hlltc sketch at all rather
+// than misinterpreting arbitrary bytes as a legacy layout.
+var frameMagic = [4]byte{'H', 'L', 'L', 'C'}
+
+// flagSparse and flagDenseCompressed are bits in the framed format's
+// flags byte.
+const (
+	flagSparse          = 1 << 0
+	flagDenseCompressed = 1 << 1
+)
+
+// deflateBlock compresses b with DEFLATE. Dense register blocks compress
+// extremely well because most register values cluster near a small
+// range, so this alone recovers most of the benefit of a dedicated
+// block compressor without adding a dependency.
+func deflateBlock(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inflateBlock reverses deflateBlock.
+func inflateBlock(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// checksum computes the framed format's integrity checksum. It's
+// deliberately independent of the sketch's pluggable element hasher
+// (see WithHasher): that hasher is chosen for insert throughput, not
+// collision resistance, and isn't contractually required to be
+// deterministic across runs, neither of which is acceptable in a
+// corruption check.
+func checksum(data []byte) uint64 {
+	sum := fnv.New64a()
+	sum.Write(data)
+	return sum.Sum64()
+}
+
+// skipSections walks a sequence of length-prefixed [id byte][len uint32
+// BE][payload] trailing sections and returns the bytes left after them.
+// It doesn't need to recognize a section's id to skip it. unmarshalFramed
+// only reaches it for data whose section count is 0, though: it reads the
+// checksum at a fixed 9 bytes from the end, so data carrying any trailing
+// section fails checksum verification first.
+func skipSections(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("hlltc: truncated sketch: missing section count")
+	}
+	n := int(data[0])
+	data = data[1:]
+	for i := 0; i < n; i++ {
+		if len(data) < 5 {
+			return nil, fmt.Errorf("hlltc: truncated sketch: section %d header", i)
+		}
+		sz := int(binary.BigEndian.Uint32(data[1:5]))
+		if len(data) < 5+sz {
+			return nil, fmt.Errorf("hlltc: truncated sketch: section %d payload", i)
+		}
+		data = data[5+sz:]
+	}
+	return data, nil
+}
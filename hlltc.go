@@ -1,9 +1,11 @@
 package hlltc
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"sort"
 )
@@ -12,7 +14,19 @@ const (
 	capacity = uint8(16)
 	pp       = uint8(25)
 	mp       = uint32(1) << pp
-	version  = 1
+
+	// versionLegacy is the original wire format: no hasher identity is
+	// recorded, so UnmarshalBinary cannot detect a mismatched hasher.
+	versionLegacy = 1
+	// versionHashed adds the hasher identity described on Sketch.hashID,
+	// but is otherwise laid out exactly like versionLegacy.
+	versionHashed = 2
+	// versionFramed is the current wire format: a magic header, a flags
+	// byte, a compressed dense register block, a checksum, and trailing
+	// sections for forward compatibility. See MarshalBinary.
+	versionFramed = 3
+	// version is the format MarshalBinary writes.
+	version = versionFramed
 )
 
 // Sketch ...
@@ -26,6 +40,10 @@ type Sketch struct {
 	sparseList *compressedList
 	tmpSet     set
 	hash       func(e []byte) uint64
+	// hashID identifies hash, so a sketch serialized with one hash function
+	// can't silently be unmarshaled into a sketch using a different one.
+	// See WithHasher.
+	hashID uint64
 }
 
 // New ...
@@ -42,6 +60,7 @@ func New(precision uint8) (*Sketch, error) {
 		tmpSet:     set{},
 		sparseList: newCompressedList(int(m)),
 		hash:       hash,
+		hashID:     hasherID(defaultHasherID),
 	}, nil
 }
 
@@ -91,6 +110,72 @@ func (sk *Sketch) Merge(other *Sketch) error {
 	return nil
 }
 
+// Union returns a new Sketch holding the union of sk and others, leaving
+// sk and others unmodified. It's the non-mutating counterpart to Merge.
+func (sk *Sketch) Union(others ...*Sketch) (*Sketch, error) {
+	u, err := New(sk.p)
+	if err != nil {
+		return nil, err
+	}
+	u.hash, u.hashID = sk.hash, sk.hashID
+
+	if err := u.Merge(sk); err != nil {
+		return nil, err
+	}
+	for _, other := range others {
+		if err := u.Merge(other); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// IntersectCardinality estimates the number of elements sk and other
+// have in common via inclusion-exclusion: |A∩B| = |A| + |B| - |A∪B|.
+// Because each term is itself an estimate, the result can come out
+// slightly negative when the sketches are nearly disjoint; in that case
+// IntersectCardinality returns 0 rather than a bogus negative count.
+// Accuracy degrades as sk and other grow less similar, since the
+// estimate becomes a small difference of large, noisy numbers.
+func (sk *Sketch) IntersectCardinality(other *Sketch) (uint64, error) {
+	union, err := sk.Union(other)
+	if err != nil {
+		return 0, err
+	}
+
+	return intersectionEstimate(sk.Estimate(), other.Estimate(), union.Estimate()), nil
+}
+
+// intersectionEstimate applies IntersectCardinality's inclusion-exclusion
+// guard in isolation: a and b are the two sketches' own estimates and u
+// is their union's estimate. It returns 0 instead of a+b-u whenever that
+// would be negative, which as a uint64 would otherwise wrap around to a
+// huge bogus count.
+func intersectionEstimate(a, b, u uint64) uint64 {
+	if a+b < u {
+		return 0
+	}
+	return a + b - u
+}
+
+// Jaccard estimates the Jaccard index between sk and other, |A∩B|/|A∪B|,
+// a similarity measure between 0 (disjoint) and 1 (identical). Like
+// IntersectCardinality, its accuracy degrades as the two sketches grow
+// less similar.
+func (sk *Sketch) Jaccard(other *Sketch) (float64, error) {
+	union, err := sk.Union(other)
+	if err != nil {
+		return 0, err
+	}
+	u := union.Estimate()
+	if u == 0 {
+		return 0, nil
+	}
+
+	intersection := intersectionEstimate(sk.Estimate(), other.Estimate(), u)
+	return float64(intersection) / float64(u), nil
+}
+
 // Convert from sparse representation to dense representation.
 func (sk *Sketch) toNormal() {
 	if len(sk.tmpSet) > 0 {
@@ -142,6 +227,56 @@ func (sk *Sketch) Insert(e []byte) {
 	}
 }
 
+// InsertString is a convenience wrapper around Insert for string elements,
+// saving callers an explicit []byte(s) conversion at each call site.
+func (sk *Sketch) InsertString(s string) {
+	sk.Insert([]byte(s))
+}
+
+// InsertMany inserts a batch of elements, hoisting the sparse/dense branch
+// check out of the loop. In sparse mode it adds every hash to tmpSet and
+// merges into sparseList once at the end, rather than re-checking the
+// merge threshold after every element as Insert does; this amortizes the
+// sort-and-dedupe cost in mergeSparse across the whole batch.
+func (sk *Sketch) InsertMany(elements [][]byte) {
+	if sk.sparse {
+		for _, e := range elements {
+			x := sk.hash(e)
+			sk.tmpSet.add(encodeHash(x, sk.p, pp))
+		}
+		sk.mergeSparse()
+		if uint32(sk.sparseList.Len()) > sk.m {
+			sk.toNormal()
+		}
+		return
+	}
+
+	for _, e := range elements {
+		x := sk.hash(e)
+		i, r := getPosVal(x, sk.p)
+		sk.insert(uint32(i), r)
+	}
+}
+
+// sketchWriter adapts a Sketch to io.Writer, inserting each Write call's
+// bytes as a single element.
+type sketchWriter struct {
+	sk *Sketch
+}
+
+func (w sketchWriter) Write(p []byte) (int, error) {
+	w.sk.Insert(p)
+	return len(p), nil
+}
+
+// Writer returns an io.Writer that inserts each Write call's bytes as a
+// single element, for plugging the sketch directly into ingest hot paths
+// (e.g. bufio.Scanner output) without allocating an intermediate slice
+// per element.
+func (sk *Sketch) Writer() io.Writer {
+	return sketchWriter{sk: sk}
+}
+
 // Estimate ...
 func (sk *Sketch) Estimate() uint64 {
 	if sk.sparse {
@@ -203,62 +338,101 @@ func (sk *Sketch) mergeSparse() {
 	sk.tmpSet = set{}
 }
 
-// MarshalBinary implements the encoding.BinaryMarshaler interface.
+// MarshalBinary implements the encoding.BinaryMarshaler interface. It
+// writes the current framed format (versionFramed): a magic header, a
+// flags byte, the sketch metadata, a dense register block that's
+// DEFLATE-compressed when that's a win, a checksum over everything
+// above, and a (currently empty) list of trailing sections reserved for
+// forward-compatible extensions.
 func (sk *Sketch) MarshalBinary() (data []byte, err error) {
-	// Marshal a version marker.
+	data = append(data, frameMagic[:]...)
 	data = append(data, version)
-	// Marshal p.
+
+	var flags byte
+	if sk.sparse {
+		flags |= flagSparse
+	}
+	flagsIdx := len(data)
+	data = append(data, flags)
+
 	data = append(data, byte(sk.p))
-	// Marshal b
 	data = append(data, byte(sk.b))
 
-	if sk.sparse {
-		// It's using the sparse representation.
-		data = append(data, byte(1))
+	// Marshal the hasher identity, so UnmarshalBinary can refuse to decode
+	// this sketch into one configured with a different hash function.
+	var idBuf [8]byte
+	binary.BigEndian.PutUint64(idBuf[:], sk.hashID)
+	data = append(data, idBuf[:]...)
 
-		// Add the tmp_set
+	if sk.sparse {
+		// Add the tmp_set.
 		tsdata, err := sk.tmpSet.MarshalBinary()
 		if err != nil {
 			return nil, err
 		}
 		data = append(data, tsdata...)
 
-		// Add the sparse representation
+		// Add the sparse representation.
 		sdata, err := sk.sparseList.MarshalBinary()
 		if err != nil {
 			return nil, err
 		}
-		return append(data, sdata...), nil
-	}
-
-	// It's using the dense representation.
-	data = append(data, byte(0))
+		data = append(data, sdata...)
+	} else {
+		// Lay out the dense registers as [size][raw bytes], then try to
+		// compress that block; fall back to storing it raw if compression
+		// doesn't actually save space.
+		sz := len(sk.regs.fields)
+		raw := make([]byte, 4, 4+sz)
+		binary.BigEndian.PutUint32(raw, uint32(sz))
+		for i := 0; i < sz; i++ {
+			raw = append(raw, byte(sk.regs.fields[i]))
+		}
 
-	// Add the dense sketch representation.
-	sz := len(sk.regs.fields)
-	data = append(data, []byte{
-		byte(sz >> 24),
-		byte(sz >> 16),
-		byte(sz >> 8),
-		byte(sz),
-	}...)
+		block := raw
+		if compressed, err := deflateBlock(raw); err == nil && len(compressed) < len(raw) {
+			flags |= flagDenseCompressed
+			data[flagsIdx] = flags
+			block = compressed
+		}
 
-	// Marshal each element in the list.
-	for i := 0; i < len(sk.regs.fields); i++ {
-		data = append(data, byte(sk.regs.fields[i]))
+		var blockLen [4]byte
+		binary.BigEndian.PutUint32(blockLen[:], uint32(len(block)))
+		data = append(data, blockLen[:]...)
+		data = append(data, block...)
 	}
 
+	// Checksum everything written so far with a fixed, dedicated checksum
+	// that's independent of sk.hash: the element hasher is pluggable (see
+	// WithHasher) and may be weak or non-deterministic across runs, and
+	// this integrity check shouldn't inherit either property.
+	var sumBuf [8]byte
+	binary.BigEndian.PutUint64(sumBuf[:], checksum(data))
+	data = append(data, sumBuf[:]...)
+
+	// No trailing sections yet; the section count is always 0.
+	data = append(data, 0)
+
 	return data, nil
 }
 
-// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface. It
+// dispatches on the magic header to decide whether data is the current
+// framed format (versionFramed) or one of the older, unframed layouts
+// (versionLegacy, versionHashed), which it keeps reading for backward
+// compatibility.
 func (sk *Sketch) UnmarshalBinary(data []byte) error {
-	// Unmarshal version. We may need this in the future if we make
-	// non-compatible changes.
-	_ = data[0]
+	if len(data) >= len(frameMagic) && bytes.Equal(data[:len(frameMagic)], frameMagic[:]) {
+		return sk.unmarshalFramed(data)
+	}
+	return sk.unmarshalLegacy(data)
+}
 
-	// Unmarshal p.
-	p := uint8(data[1])
+// resetKeepingHasher reinitializes sk for precision p via New, but keeps
+// whatever hasher sk was already configured with (e.g. via
+// NewWithOptions), since New always installs the default one.
+func (sk *Sketch) resetKeepingHasher(p uint8) error {
+	prevHash, prevHashID, hasCustomHasher := sk.hash, sk.hashID, sk.hash != nil
 
 	newh, err := New(p)
 	if err != nil {
@@ -266,23 +440,53 @@ func (sk *Sketch) UnmarshalBinary(data []byte) error {
 	}
 	*sk = *newh
 
-	// Unmarshal b.
-	sk.b = uint8(data[2])
+	if hasCustomHasher {
+		sk.hash, sk.hashID = prevHash, prevHashID
+	}
+	return nil
+}
+
+// unmarshalLegacy reads the versionLegacy and versionHashed layouts:
+// [version][p][b]([hashID] if versionHashed)[sparse flag]...
+func (sk *Sketch) unmarshalLegacy(data []byte) error {
+	ver := data[0]
+	p := uint8(data[1])
+
+	if err := sk.resetKeepingHasher(p); err != nil {
+		return err
+	}
+
+	var off int
+	switch ver {
+	case versionLegacy:
+		sk.b = uint8(data[2])
+		off = 3
+	case versionHashed:
+		sk.b = uint8(data[2])
+		wantHashID := binary.BigEndian.Uint64(data[3:11])
+		if wantHashID != sk.hashID {
+			return fmt.Errorf("hlltc: sketch was serialized with a different hash function (want %d, have %d)", wantHashID, sk.hashID)
+		}
+		off = 11
+	default:
+		return fmt.Errorf("hlltc: unsupported binary version %d", ver)
+	}
 
 	// h is now initialised with the correct p. We just need to fill the
 	// rest of the details out.
-	if data[3] == byte(1) {
+	if data[off] == byte(1) {
 		// Using the sparse representation.
 		sk.sparse = true
 
 		// Unmarshal the tmp_set.
-		tssz := binary.BigEndian.Uint32(data[4:8])
+		tsStart := off + 1
+		tssz := binary.BigEndian.Uint32(data[tsStart : tsStart+4])
 		sk.tmpSet = make(map[uint32]struct{}, tssz)
 
 		// We need to unmarshal tssz values in total, and each value requires us
 		// to read 4 bytes.
-		tsLastByte := int((tssz * 4) + 8)
-		for i := 8; i < tsLastByte; i += 4 {
+		tsLastByte := int(tssz*4) + tsStart + 4
+		for i := tsStart + 4; i < tsLastByte; i += 4 {
 			k := binary.BigEndian.Uint32(data[i : i+4])
 			sk.tmpSet[k] = struct{}{}
 		}
@@ -295,9 +499,10 @@ func (sk *Sketch) UnmarshalBinary(data []byte) error {
 	sk.sparse = false
 	sk.sparseList = nil
 	sk.tmpSet = nil
-	dsz := binary.BigEndian.Uint32(data[4:8])
+	dszStart := off + 1
+	dsz := binary.BigEndian.Uint32(data[dszStart : dszStart+4])
 	sk.regs = newRegisters(dsz * 2)
-	data = data[8:]
+	data = data[dszStart+4:]
 
 	for i, val := range data {
 		sk.regs.fields[i] = reg(val)
@@ -311,3 +516,106 @@ func (sk *Sketch) UnmarshalBinary(data []byte) error {
 
 	return nil
 }
+
+// unmarshalFramed reads the versionFramed layout written by
+// MarshalBinary: magic(4) version(1) flags(1) p(1) b(1) hashID(8)
+// payload... checksum(8) sectionCount(1) sections...
+// The checksum is read at a fixed 9 bytes from the end of data, so only
+// data with a section count of 0 and no sections decodes; data carrying
+// trailing sections fails checksum verification.
+func (sk *Sketch) unmarshalFramed(data []byte) error {
+	const headerLen = 16 // magic + version + flags + p + b + hashID
+	if len(data) < headerLen+8+1 {
+		return errors.New("hlltc: truncated sketch header")
+	}
+
+	ver := data[4]
+	if ver != versionFramed {
+		return fmt.Errorf("hlltc: unsupported binary version %d", ver)
+	}
+	flags := data[5]
+	p := uint8(data[6])
+
+	if err := sk.resetKeepingHasher(p); err != nil {
+		return err
+	}
+	sk.b = uint8(data[7])
+
+	wantHashID := binary.BigEndian.Uint64(data[8:headerLen])
+	if wantHashID != sk.hashID {
+		return fmt.Errorf("hlltc: sketch was serialized with a different hash function (want %d, have %d)", wantHashID, sk.hashID)
+	}
+
+	checksumStart := len(data) - 9
+	wantSum := binary.BigEndian.Uint64(data[checksumStart : checksumStart+8])
+	if gotSum := checksum(data[:checksumStart]); gotSum != wantSum {
+		return fmt.Errorf("hlltc: sketch failed checksum verification (want %d, got %d)", wantSum, gotSum)
+	}
+	// Trailing sections are reserved for future use; walk the section
+	// list to check it is well formed, without interpreting it.
+	if _, err := skipSections(data[checksumStart+8:]); err != nil {
+		return err
+	}
+
+	payload := data[headerLen:checksumStart]
+
+	if flags&flagSparse != 0 {
+		sk.sparse = true
+
+		if len(payload) < 4 {
+			return errors.New("hlltc: truncated sparse tmp set")
+		}
+		tssz := binary.BigEndian.Uint32(payload[:4])
+		sk.tmpSet = make(map[uint32]struct{}, tssz)
+
+		tsLastByte := int(tssz*4) + 4
+		if len(payload) < tsLastByte {
+			return errors.New("hlltc: truncated sparse tmp set")
+		}
+		for i := 4; i < tsLastByte; i += 4 {
+			k := binary.BigEndian.Uint32(payload[i : i+4])
+			sk.tmpSet[k] = struct{}{}
+		}
+
+		return sk.sparseList.UnmarshalBinary(payload[tsLastByte:])
+	}
+
+	sk.sparse = false
+	sk.sparseList = nil
+	sk.tmpSet = nil
+
+	if len(payload) < 4 {
+		return errors.New("hlltc: truncated dense register block")
+	}
+	blockLen := binary.BigEndian.Uint32(payload[:4])
+	if uint64(len(payload)-4) < uint64(blockLen) {
+		return errors.New("hlltc: truncated dense register block")
+	}
+	block := payload[4 : 4+blockLen]
+	if flags&flagDenseCompressed != 0 {
+		raw, err := inflateBlock(block)
+		if err != nil {
+			return err
+		}
+		block = raw
+	}
+	if len(block) < 4 {
+		return errors.New("hlltc: truncated dense register block")
+	}
+
+	dsz := binary.BigEndian.Uint32(block[:4])
+	sk.regs = newRegisters(dsz * 2)
+	block = block[4:]
+
+	for i, val := range block {
+		sk.regs.fields[i] = reg(val)
+		if uint8(sk.regs.fields[i]<<4>>4) > 0 {
+			sk.regs.nz--
+		}
+		if uint8(sk.regs.fields[i]>>4) > 0 {
+			sk.regs.nz--
+		}
+	}
+
+	return nil
+}
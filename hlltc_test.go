@@ -0,0 +1,205 @@
+package hlltc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMarshalUnmarshalFramedRoundTripSparse(t *testing.T) {
+	sk, err := New(14)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		sk.InsertString(fmt.Sprintf("sparse-elem-%d", i))
+	}
+	if !sk.IsSparse() {
+		t.Fatal("expected sketch to still be sparse after 50 inserts at p=14")
+	}
+
+	data, err := sk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	got, err := New(14)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+
+	if got.Precision() != sk.Precision() {
+		t.Errorf("Precision() = %d, want %d", got.Precision(), sk.Precision())
+	}
+	if got.IsSparse() != sk.IsSparse() {
+		t.Errorf("IsSparse() = %v, want %v", got.IsSparse(), sk.IsSparse())
+	}
+	if got.Estimate() != sk.Estimate() {
+		t.Errorf("Estimate() = %d, want %d", got.Estimate(), sk.Estimate())
+	}
+}
+
+func TestMarshalUnmarshalFramedRoundTripDense(t *testing.T) {
+	sk, err := New(4)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		sk.InsertString(fmt.Sprintf("dense-elem-%d", i))
+	}
+	if sk.IsSparse() {
+		t.Fatal("expected sketch to have converted to dense after 500 inserts at p=4")
+	}
+
+	data, err := sk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	got, err := New(4)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+
+	if got.IsSparse() {
+		t.Error("expected unmarshaled sketch to still be dense")
+	}
+	if got.Estimate() != sk.Estimate() {
+		t.Errorf("Estimate() = %d, want %d", got.Estimate(), sk.Estimate())
+	}
+}
+
+// TestUnmarshalFramedDenseOversizedBlockLen crafts a dense-mode blob whose
+// blockLen claims a block far longer than the payload actually holds (with
+// the checksum patched to match, so that check doesn't catch it first).
+// unmarshalFramed must report this as truncated data rather than slicing
+// past the end of payload.
+func TestUnmarshalFramedDenseOversizedBlockLen(t *testing.T) {
+	sk, err := New(4)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 500; i++ {
+		sk.InsertString(fmt.Sprintf("dense-elem-%d", i))
+	}
+	if sk.IsSparse() {
+		t.Fatal("expected sketch to have converted to dense after 500 inserts at p=4")
+	}
+
+	data, err := sk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	const headerLen = 16
+	binary.BigEndian.PutUint32(data[headerLen:headerLen+4], 0xFFFFFFF0)
+
+	checksumStart := len(data) - 9
+	binary.BigEndian.PutUint64(data[checksumStart:checksumStart+8], checksum(data[:checksumStart]))
+
+	got, err := New(4)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := got.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected an error unmarshaling a blob claiming an oversized dense block, got nil")
+	} else if !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("error = %q, want it to mention truncation", err.Error())
+	}
+}
+
+func TestUnmarshalFramedTruncated(t *testing.T) {
+	sk, err := New(10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	sk.InsertString("hello")
+	data, err := sk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	got, err := New(10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := got.UnmarshalBinary(data[:len(data)/2]); err == nil {
+		t.Fatal("expected an error unmarshaling truncated data, got nil")
+	}
+}
+
+func TestUnmarshalFramedChecksumMismatch(t *testing.T) {
+	sk, err := New(10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		sk.InsertString(fmt.Sprintf("elem-%d", i))
+	}
+	data, err := sk.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	// Flip a bit in the middle of the payload, well clear of the header
+	// and the trailing checksum/section-count bytes.
+	data[len(data)/2] ^= 0xFF
+
+	got, err := New(10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := got.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected a checksum error unmarshaling corrupted data, got nil")
+	} else if !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("error = %q, want it to mention checksum", err.Error())
+	}
+}
+
+// legacyDense hand-builds the versionLegacy dense layout documented on
+// unmarshalLegacy: [version][p][b][sparse flag=0][dsz uint32 BE][raw
+// register bytes]. It exists only to exercise the backward-compatibility
+// path; versionLegacy is no longer written by MarshalBinary.
+func legacyDense(p, b uint8, regs []byte) []byte {
+	data := []byte{versionLegacy, p, b, 0}
+	var dsz [4]byte
+	binary.BigEndian.PutUint32(dsz[:], uint32(len(regs)))
+	data = append(data, dsz[:]...)
+	data = append(data, regs...)
+	return data
+}
+
+func TestUnmarshalLegacyDense(t *testing.T) {
+	sk, err := New(4) // m=16, so 8 packed register bytes
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	data := legacyDense(4, 0, make([]byte, 8))
+	if err := sk.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if sk.IsSparse() {
+		t.Error("expected a dense sketch after decoding the legacy dense layout")
+	}
+	if sk.Precision() != 4 {
+		t.Errorf("Precision() = %d, want 4", sk.Precision())
+	}
+}
+
+func TestUnmarshalLegacyUnsupportedVersion(t *testing.T) {
+	sk, err := New(4)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := sk.UnmarshalBinary([]byte{99, 4, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Fatal("expected an error for an unsupported version byte, got nil")
+	}
+}
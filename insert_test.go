@@ -0,0 +1,118 @@
+package hlltc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func elementBatch(prefix string, n int) [][]byte {
+	elems := make([][]byte, n)
+	for i := range elems {
+		elems[i] = []byte(fmt.Sprintf("%s-%d", prefix, i))
+	}
+	return elems
+}
+
+// TestInsertManyMatchesSequentialInsertSparse checks that InsertMany's
+// batched tmpSet/merge path produces the same sketch state as calling
+// Insert once per element, while both stay sparse.
+func TestInsertManyMatchesSequentialInsertSparse(t *testing.T) {
+	elems := elementBatch("sparse-elem", 50)
+
+	sequential, err := New(14)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, e := range elems {
+		sequential.Insert(e)
+	}
+	if !sequential.IsSparse() {
+		t.Fatal("expected sequential sketch to still be sparse after 50 inserts at p=14")
+	}
+
+	batched, err := New(14)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	batched.InsertMany(elems)
+	if !batched.IsSparse() {
+		t.Fatal("expected batched sketch to still be sparse after 50 inserts at p=14")
+	}
+
+	if batched.Estimate() != sequential.Estimate() {
+		t.Errorf("Estimate() = %d, want %d (same as sequential Insert)", batched.Estimate(), sequential.Estimate())
+	}
+}
+
+// newDenseSketch builds a precision-4 sketch and inserts just enough
+// filler elements, one at a time, to force it past the sparse->dense
+// conversion threshold, so callers can compare InsertMany against
+// sequential Insert entirely within the dense branch of both.
+func newDenseSketch(t *testing.T) *Sketch {
+	t.Helper()
+	sk, err := New(4)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, e := range elementBatch("filler", 20) {
+		sk.Insert(e)
+	}
+	if sk.IsSparse() {
+		t.Fatal("expected sketch to have converted to dense after 20 filler inserts at p=4")
+	}
+	return sk
+}
+
+// TestInsertManyMatchesSequentialInsertDense is the same check as
+// TestInsertManyMatchesSequentialInsertSparse, but with both sketches
+// already dense before the comparison batch runs: InsertMany's dense
+// branch is otherwise identical to what sequential Insert does, one
+// element at a time.
+func TestInsertManyMatchesSequentialInsertDense(t *testing.T) {
+	elems := elementBatch("dense-elem", 500)
+
+	sequential := newDenseSketch(t)
+	for _, e := range elems {
+		sequential.Insert(e)
+	}
+
+	batched := newDenseSketch(t)
+	batched.InsertMany(elems)
+
+	if batched.Estimate() != sequential.Estimate() {
+		t.Errorf("Estimate() = %d, want %d (same as sequential Insert)", batched.Estimate(), sequential.Estimate())
+	}
+}
+
+// TestWriterMatchesInsert checks that writing elements through sk.Writer()
+// has the same effect as calling Insert directly with the same elements.
+func TestWriterMatchesInsert(t *testing.T) {
+	elems := elementBatch("writer-elem", 200)
+
+	viaInsert, err := New(10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, e := range elems {
+		viaInsert.Insert(e)
+	}
+
+	viaWriter, err := New(10)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	w := viaWriter.Writer()
+	for _, e := range elems {
+		n, err := w.Write(e)
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if n != len(e) {
+			t.Errorf("Write returned n = %d, want %d", n, len(e))
+		}
+	}
+
+	if viaWriter.Estimate() != viaInsert.Estimate() {
+		t.Errorf("Estimate() = %d, want %d (same as direct Insert)", viaWriter.Estimate(), viaInsert.Estimate())
+	}
+}
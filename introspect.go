@@ -0,0 +1,60 @@
+package hlltc
+
+// Precision returns the sketch's precision parameter p, as passed to New
+// or NewWithOptions.
+func (sk *Sketch) Precision() uint8 {
+	return sk.p
+}
+
+// IsSparse reports whether the sketch is currently using the sparse
+// representation.
+func (sk *Sketch) IsSparse() bool {
+	return sk.sparse
+}
+
+// ForEachRegister calls fn once per register, with its index and its
+// value as an absolute rank (the number of leading zero bits plus one,
+// as computed by decodeHash/insert). In sparse mode this first merges
+// tmpSet into sparseList and decodes the sparse entries, which already
+// store absolute ranks, keeping the largest rank per register index and
+// reporting 0 for registers with no entry; in dense mode it walks the
+// packed register array directly and adds back sk.b, the rebase offset
+// insert subtracted when it stored the value, so both modes report the
+// same quantity. A dense register holds at most capacity-1 above sk.b,
+// so a larger rank is reported as sk.b+capacity-1.
+// It's the building block for observability tooling (register bias
+// plots, drift detection between two sketches) and alternative
+// estimators (e.g. MLE-based) that need access to raw register state.
+func (sk *Sketch) ForEachRegister(fn func(index uint32, value uint8)) {
+	if sk.sparse {
+		sk.mergeSparse()
+		vals := make([]uint8, sk.m)
+		for iter := sk.sparseList.Iter(); iter.HasNext(); {
+			i, r := decodeHash(iter.Next(), sk.p, pp)
+			if r > vals[i] {
+				vals[i] = r
+			}
+		}
+		for i, v := range vals {
+			fn(uint32(i), v)
+		}
+		return
+	}
+
+	for i, v := range sk.regs.fields {
+		fn(uint32(i)*2, v.get(0)+sk.b)
+		fn(1+uint32(i)*2, v.get(1)+sk.b)
+	}
+}
+
+// RegisterHistogram returns a histogram of register values: the result's
+// index v holds the number of registers currently set to value v.
+func (sk *Sketch) RegisterHistogram() [64]uint32 {
+	var hist [64]uint32
+	sk.ForEachRegister(func(_ uint32, value uint8) {
+		if int(value) < len(hist) {
+			hist[value]++
+		}
+	})
+	return hist
+}
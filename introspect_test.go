@@ -0,0 +1,129 @@
+package hlltc
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestForEachRegisterSparseToDenseConversionPreservesAbsoluteRank checks
+// that ForEachRegister reports the same absolute ranks right before and
+// right after a sparse sketch converts to dense: toNormal decodes the
+// exact sparseList entries ForEachRegister's sparse branch already reads,
+// so the two views must agree exactly.
+func TestForEachRegisterSparseToDenseConversionPreservesAbsoluteRank(t *testing.T) {
+	sk, err := New(18)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	buf := make([]byte, 16)
+	for i := 0; i < 5000; i++ {
+		rnd.Read(buf)
+		sk.Insert(buf)
+	}
+	if !sk.IsSparse() {
+		t.Fatal("expected sketch to still be sparse after 5000 inserts at p=18")
+	}
+
+	before := make(map[uint32]uint8)
+	sk.ForEachRegister(func(idx uint32, v uint8) {
+		if v > before[idx] {
+			before[idx] = v
+		}
+	})
+	if len(before) == 0 {
+		t.Fatal("expected at least one register after inserts")
+	}
+
+	sk.toNormal()
+	if sk.IsSparse() {
+		t.Fatal("expected sketch to be dense after toNormal")
+	}
+
+	after := make(map[uint32]uint8)
+	sk.ForEachRegister(func(idx uint32, v uint8) {
+		if v > after[idx] {
+			after[idx] = v
+		}
+	})
+	for idx, v := range before {
+		if after[idx] != v {
+			t.Errorf("register %d = %d right after sparse->dense conversion, want %d (same as sparse view)", idx, after[idx], v)
+		}
+	}
+}
+
+// TestForEachRegisterReportsAbsoluteRankAfterRebase forces a dense sketch
+// through at least one rebase (sk.b > 0), then checks that ranks recorded
+// before the rebase never appear to fall afterward: the dense branch
+// reconstructs a register's absolute rank by adding sk.b back to the
+// relative value it stores internally, and forgetting that would make
+// previously-recorded ranks look smaller once sk.b grows.
+func TestForEachRegisterReportsAbsoluteRankAfterRebase(t *testing.T) {
+	sk, err := New(4)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	buf := make([]byte, 16)
+	for i := 0; i < 100; i++ {
+		rnd.Read(buf)
+		sk.Insert(buf)
+	}
+	if sk.IsSparse() {
+		t.Fatal("expected sketch to have converted to dense representation")
+	}
+
+	before := make(map[uint32]uint8)
+	sk.ForEachRegister(func(idx uint32, v uint8) {
+		before[idx] = v
+	})
+
+	const maxExtra = 2000000
+	extra := 0
+	for ; extra < maxExtra && sk.b == 0; extra++ {
+		rnd.Read(buf)
+		sk.Insert(buf)
+	}
+	if sk.b == 0 {
+		t.Fatalf("expected a rebase within %d extra inserts at p=4", maxExtra)
+	}
+
+	sk.ForEachRegister(func(idx uint32, v uint8) {
+		if v < before[idx] {
+			t.Errorf("register %d = %d after rebase (sk.b=%d), want >= %d (absolute rank can only grow)", idx, v, sk.b, before[idx])
+		}
+	})
+}
+
+// TestRegisterHistogramMatchesForEachRegister checks that RegisterHistogram
+// is just a tally over the same (index, value) pairs ForEachRegister
+// yields, in both sparse and dense mode.
+func TestRegisterHistogramMatchesForEachRegister(t *testing.T) {
+	for _, p := range []uint8{4, 14} {
+		sk, err := New(p)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		rnd := rand.New(rand.NewSource(2))
+		buf := make([]byte, 16)
+		for i := 0; i < 500; i++ {
+			rnd.Read(buf)
+			sk.Insert(buf)
+		}
+
+		var want [64]uint32
+		sk.ForEachRegister(func(_ uint32, v uint8) {
+			if int(v) < len(want) {
+				want[v]++
+			}
+		})
+
+		got := sk.RegisterHistogram()
+		if got != want {
+			t.Errorf("p=%d: RegisterHistogram() = %v, want %v", p, got, want)
+		}
+	}
+}
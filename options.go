@@ -0,0 +1,77 @@
+package hlltc
+
+import (
+	"hash/fnv"
+)
+
+// Option configures a Sketch built by NewWithOptions.
+type Option func(*Sketch)
+
+// defaultHasherID identifies the murmur-based hasher New installs before
+// any options run.
+const defaultHasherID = "hlltc/murmur3-default"
+
+// WithHasher overrides the hash function New uses to map inserted elements
+// to 64-bit hashes (the default is murmur-based). id is recorded in the
+// sketch's binary format, so UnmarshalBinary can refuse to decode a sketch
+// that was serialized with a different hasher instead of silently
+// producing a wrong estimate.
+//
+// id must be supplied explicitly rather than derived from h: a closure's
+// code pointer doesn't capture the state it closes over, so two keyed
+// hashers built from the same function literal with different keys (the
+// motivating use case for this option) are otherwise indistinguishable.
+// Callers should pick an id that changes whenever the hash's behavior
+// does, e.g. including a key or version in the string.
+func WithHasher(id string, h func(e []byte) uint64) Option {
+	return func(sk *Sketch) {
+		sk.hash = h
+		sk.hashID = hasherID(id)
+	}
+}
+
+// WithSeed folds seed into every hash the sketch's hasher produces. It
+// composes with whichever hasher is already configured (the default, or
+// one set by an earlier WithHasher), so callers can decorrelate sketches
+// built over the same elements without writing their own hash function.
+func WithSeed(seed uint64) Option {
+	return func(sk *Sketch) {
+		base := sk.hash
+		sk.hash = func(e []byte) uint64 {
+			// XOR alone flips the same fixed bits of every hash, which for
+			// a small seed leaves register indexes unchanged; the
+			// splitmix64 finalizer spreads the seed across every bit.
+			x := base(e) ^ seed
+			x ^= x >> 30
+			x *= 0xbf58476d1ce4e5b9
+			x ^= x >> 27
+			x *= 0x94d049bb133111eb
+			x ^= x >> 31
+			return x
+		}
+		sk.hashID ^= seed
+	}
+}
+
+// NewWithOptions is like New but accepts functional options to customize
+// the sketch's hash function, for example to plug in xxhash or a keyed
+// hash without forking the package.
+func NewWithOptions(precision uint8, opts ...Option) (*Sketch, error) {
+	sk, err := New(precision)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(sk)
+	}
+	return sk, nil
+}
+
+// hasherID derives a stable 64-bit identity from a caller-supplied hasher
+// id string, so the same id maps to the same value across processes and
+// can be round-tripped through MarshalBinary/UnmarshalBinary.
+func hasherID(id string) uint64 {
+	sum := fnv.New64a()
+	sum.Write([]byte(id))
+	return sum.Sum64()
+}
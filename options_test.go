@@ -0,0 +1,106 @@
+package hlltc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewWithOptionsAppliesOptions(t *testing.T) {
+	var calls int
+	sk, err := NewWithOptions(10, func(sk *Sketch) { calls++ })
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("option called %d times, want 1", calls)
+	}
+	if sk.Precision() != 10 {
+		t.Errorf("Precision() = %d, want 10", sk.Precision())
+	}
+}
+
+func TestNewWithOptionsInvalidPrecision(t *testing.T) {
+	if _, err := NewWithOptions(3); err == nil {
+		t.Fatal("expected an error for an out-of-range precision, got nil")
+	}
+}
+
+// TestWithHasherMismatchRejectedOnUnmarshal builds two sketches with
+// differently-identified custom hashers and checks that unmarshaling one
+// sketch's data into the other is rejected, per WithHasher's documented
+// contract.
+func TestWithHasherMismatchRejectedOnUnmarshal(t *testing.T) {
+	a, err := NewWithOptions(10, WithHasher("a", hash))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		a.InsertString(fmt.Sprintf("elem-%d", i))
+	}
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	b, err := NewWithOptions(10, WithHasher("b", hash))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	if err := b.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected an error unmarshaling into a sketch with a different hasher id, got nil")
+	} else if !strings.Contains(err.Error(), "different hash function") {
+		t.Errorf("error = %q, want it to mention the hash function mismatch", err.Error())
+	}
+}
+
+// TestWithHasherSameIDRoundTrips is the mirror of the mismatch case: two
+// sketches built with the same hasher id should round-trip through
+// Marshal/Unmarshal just like the default hasher does.
+func TestWithHasherSameIDRoundTrips(t *testing.T) {
+	a, err := NewWithOptions(10, WithHasher("shared", hash))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		a.InsertString(fmt.Sprintf("elem-%d", i))
+	}
+	data, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	b, err := NewWithOptions(10, WithHasher("shared", hash))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	if err := b.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if b.Estimate() != a.Estimate() {
+		t.Errorf("Estimate() = %d, want %d", b.Estimate(), a.Estimate())
+	}
+}
+
+// TestWithSeedDecorrelatesHasher checks WithSeed's documented effect: it
+// composes with the existing hasher rather than replacing it, so the same
+// elements land in different registers under different seeds.
+func TestWithSeedDecorrelatesHasher(t *testing.T) {
+	unseeded, err := NewWithOptions(10, WithHasher("seed-test", hash))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	seeded, err := NewWithOptions(10, WithHasher("seed-test", hash), WithSeed(42))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+
+	if unseeded.hashID == seeded.hashID {
+		t.Error("expected WithSeed to change the sketch's hashID")
+	}
+
+	elem := []byte("some-element")
+	if unseeded.hash(elem) == seeded.hash(elem) {
+		t.Error("expected WithSeed to change the hash of the same element")
+	}
+}
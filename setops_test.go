@@ -0,0 +1,51 @@
+package hlltc
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestIntersectionEstimateGuard(t *testing.T) {
+	tests := []struct {
+		a, b, u uint64
+		want    uint64
+	}{
+		{a: 3, b: 4, u: 100, want: 0},  // union noise puts u above a+b
+		{a: 10, b: 10, u: 15, want: 5}, // normal inclusion-exclusion
+		{a: 0, b: 0, u: 0, want: 0},
+		{a: 5, b: 5, u: 5, want: 5},
+	}
+	for _, tt := range tests {
+		if got := intersectionEstimate(tt.a, tt.b, tt.u); got != tt.want {
+			t.Errorf("intersectionEstimate(%d, %d, %d) = %d, want %d", tt.a, tt.b, tt.u, got, tt.want)
+		}
+	}
+}
+
+func TestUnionIntersectJaccard(t *testing.T) {
+	a, err := New(14)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	b, err := New(14)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for i := 0; i < 150; i++ {
+		a.InsertString(fmt.Sprintf("elem-%d", i))
+	}
+	for i := 100; i < 250; i++ {
+		b.InsertString(fmt.Sprintf("elem-%d", i))
+	}
+
+	if _, err := a.Union(b); err != nil {
+		t.Fatalf("Union: %v", err)
+	}
+	if _, err := a.IntersectCardinality(b); err != nil {
+		t.Fatalf("IntersectCardinality: %v", err)
+	}
+	if _, err := a.Jaccard(b); err != nil {
+		t.Fatalf("Jaccard: %v", err)
+	}
+}